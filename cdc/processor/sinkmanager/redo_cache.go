@@ -14,50 +14,514 @@
 package sinkmanager
 
 import (
+	"container/list"
 	"sort"
+	"strconv"
 	"sync"
-	"sync/atomic"
+	"time"
 
 	"github.com/pingcap/tiflow/cdc/model"
 	"github.com/pingcap/tiflow/cdc/processor/sourcemanager/engine"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+const (
+	// defaultTableWeight is the weight assigned to a table that hasn't had
+	// its weight adjusted through SetTableWeight.
+	defaultTableWeight = 1
+
+	// highWatermarkRatio is the fraction of capacity at which push starts
+	// blocking instead of admitting more events.
+	highWatermarkRatio = 0.9
+
+	// ewmaAlpha is the smoothing factor used for the ingress/egress rate
+	// and latency EWMAs: ewma = alpha*sample + (1-alpha)*ewma.
+	ewmaAlpha = 0.2
+
+	// minSampleInterval guards against divide-by-zero/huge-rate samples
+	// when two pushes or pops land within the same clock tick.
+	minSampleInterval = time.Millisecond
+
+	// capacityAdjustInterval is how often the background goroutine
+	// recomputes the effective capacity from the ingress EWMA.
+	capacityAdjustInterval = 5 * time.Second
+
+	// defaultTargetLatency is the amount of buffering, expressed as time,
+	// that the adaptive capacity aims to keep: effective capacity tracks
+	// ingressEWMA * defaultTargetLatency.
+	defaultTargetLatency = 3 * time.Second
+
+	// defaultBlockTimeout bounds how long push waits for space to free up
+	// once the high watermark is hit before it gives up and marks the
+	// appender broken.
+	defaultBlockTimeout = 30 * time.Second
+
+	// reclaimInterval is how often the background reclaimer checks
+	// whether softCapacity has been exceeded.
+	reclaimInterval = time.Second
+
+	// lowWatermarkRatio is how far below softCapacity the reclaimer drains
+	// usage to before it stops evicting, so it doesn't thrash right at the
+	// boundary.
+	lowWatermarkRatio = 0.7
+
+	// DefaultAppendPipelineSize is the default maxBatches argument for
+	// popPipeline: how many independent transactions it returns per call,
+	// so a caller can pipeline writes to the redo log up to that depth
+	// before waiting for acks. Nothing in this tree calls popPipeline yet
+	// -- the redo worker loop still drains tables one transaction at a
+	// time via pop, and there is no `redo.append-pipeline-size`
+	// changefeed config knob wired to this constant.
+	//
+	// TODO(sinkmanager owner): this request is API-only as it stands.
+	// popPipeline and this constant are the building block; wiring the
+	// redo worker loop to call popPipeline instead of pop, and adding the
+	// `redo.append-pipeline-size` config field, is a separate follow-up
+	// that needs its own review -- don't treat this request as fully
+	// delivered until that follow-up lands.
+	DefaultAppendPipelineSize = 8
+)
+
+// RedoEventCacheTableUsage records how many bytes of a given table are
+// currently held in redoEventCache. It's labelled by table_id so operators
+// can spot which tables are consuming most of the shared budget.
+var RedoEventCacheTableUsage = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "ticdc",
+		Subsystem: "sinkmanager",
+		Name:      "redo_event_cache_table_bytes",
+		Help:      "The number of bytes cached in redoEventCache for a given table.",
+	}, []string{"namespace", "changefeed", "table_id"})
+
+// RedoEventCacheEvictions counts, per table, how many ready events have
+// been evicted from redoEventCache by the LRU reclaimer under memory
+// pressure, so operators can see which tables are thrashing the cache.
+var RedoEventCacheEvictions = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "ticdc",
+		Subsystem: "sinkmanager",
+		Name:      "redo_event_cache_evictions_total",
+		Help:      "The number of ready events evicted from redoEventCache for a given table.",
+	}, []string{"namespace", "changefeed", "table_id"})
+
+// RedoEventCacheTableIngressRate and RedoEventCacheTableEgressRate expose
+// each table's push/pop EWMA rate, so operators (and, in principle,
+// SetTableWeight callers) can see which tables are actually driving the
+// cache's capacity and which are lagging behind their ingress.
+var (
+	RedoEventCacheTableIngressRate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "ticdc",
+			Subsystem: "sinkmanager",
+			Name:      "redo_event_cache_table_ingress_bytes_per_sec",
+			Help:      "EWMA of bytes pushed into redoEventCache per second, for a given table.",
+		}, []string{"namespace", "changefeed", "table_id"})
+
+	RedoEventCacheTableEgressRate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "ticdc",
+			Subsystem: "sinkmanager",
+			Name:      "redo_event_cache_table_egress_bytes_per_sec",
+			Help:      "EWMA of bytes popped from redoEventCache per second, for a given table.",
+		}, []string{"namespace", "changefeed", "table_id"})
+)
+
+// TablePositionTracker is implemented by an EventSortEngine (or a thin
+// wrapper around one) so redoEventCache can hand back the position of
+// events it evicted under memory pressure, letting the engine know they
+// must be refetched before they're needed again.
+type TablePositionTracker interface {
+	// ResetPosition rewinds tableID's read position to pos, so that
+	// events at or after pos are fetched again from the engine.
+	ResetPosition(tableID model.TableID, pos engine.Position)
+}
+
 // redoEventCache caches events fetched from EventSortEngine.
+//
+// Its effective capacity is self-tuning: a background goroutine sums each
+// table's ingress-rate EWMA (see eventAppender) and resizes the budget to
+// hold roughly targetLatency worth of events -- or latencyEWMA worth, if
+// the sink is currently running slower than that -- clamped to
+// [minCapacity, maxCapacity]. Admission uses a soft high watermark instead
+// of a hard cutoff: once `allocated` crosses `highWatermarkRatio` of
+// capacity, push blocks on `cond` until pop frees enough space, falling
+// back to the old `broken` behavior only after `blockTimeout` elapses.
+//
+// A second background goroutine reclaims from over-share tables: once
+// `allocated` exceeds `softCapacity`, the reclaimer evicts ready events
+// starting with whichever table is furthest past its weighted
+// `tableCapacity` (handing their position back to `tracker`), using an
+// LRU list (touched on getAppender/push/pop) only to break ties between
+// equally over-share tables, until usage drops to `lowWatermarkRatio` of
+// softCapacity.
 type redoEventCache struct {
-	capacity  uint64 // it's a constant.
-	allocated uint64 // atomically shared in several goroutines.
+	changefeedID model.ChangeFeedID
+
+	mu   sync.Mutex
+	cond *sync.Cond
 
-	mu     sync.Mutex
-	tables map[model.TableID]*eventAppender
+	capacity      uint64 // current effective capacity, adjusted periodically.
+	minCapacity   uint64
+	maxCapacity   uint64
+	softCapacity  uint64 // reclaim threshold; independent of capacity.
+	targetLatency time.Duration
+	blockTimeout  time.Duration
+	allocated     uint64
+
+	// latencyEWMA is a cache-wide estimate of how long an event sits
+	// between push and pop, sampled on every pop; adjustCapacity uses it
+	// so the budget grows to cover a slow sink instead of evicting data
+	// the sink hasn't had time to drain yet.
+	latencyEWMA time.Duration
+
+	tables      map[model.TableID]*eventAppender
+	totalWeight uint64     // sum of the weight of every table in `tables`.
+	lru         *list.List // front = most recently touched, back = coldest.
+
+	tracker TablePositionTracker
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
 
 	metricRedoEventCache prometheus.Gauge
+	metricTableUsage     *prometheus.GaugeVec
+	metricEvictions      *prometheus.CounterVec
+	metricIngressRate    *prometheus.GaugeVec
+	metricEgressRate     *prometheus.GaugeVec
 }
 
-// newRedoEventCache creates a redoEventCache instance.
-func newRedoEventCache(changefeedID model.ChangeFeedID, capacity uint64) *redoEventCache {
-	return &redoEventCache{
-		capacity:  capacity,
-		allocated: 0,
-		tables:    make(map[model.TableID]*eventAppender),
+// newRedoEventCache creates a redoEventCache instance whose effective
+// capacity adapts between minCapacity and maxCapacity as throughput
+// changes; it starts at maxCapacity and is revised every
+// capacityAdjustInterval by a background goroutine. Once `allocated`
+// exceeds softCapacity, a second background goroutine reclaims ready
+// events from the tables furthest over their weighted share, coldest
+// first among equals.
+func newRedoEventCache(changefeedID model.ChangeFeedID, minCapacity, maxCapacity, softCapacity uint64) *redoEventCache {
+	r := &redoEventCache{
+		changefeedID: changefeedID,
+
+		capacity:      maxCapacity,
+		minCapacity:   minCapacity,
+		maxCapacity:   maxCapacity,
+		softCapacity:  softCapacity,
+		targetLatency: defaultTargetLatency,
+		blockTimeout:  defaultBlockTimeout,
+
+		tables:  make(map[model.TableID]*eventAppender),
+		lru:     list.New(),
+		closeCh: make(chan struct{}),
 
 		metricRedoEventCache: RedoEventCache.WithLabelValues(changefeedID.Namespace, changefeedID.ID),
+		metricTableUsage:     RedoEventCacheTableUsage,
+		metricEvictions:      RedoEventCacheEvictions,
+		metricIngressRate:    RedoEventCacheTableIngressRate,
+		metricEgressRate:     RedoEventCacheTableEgressRate,
+	}
+	r.cond = sync.NewCond(&r.mu)
+
+	r.wg.Add(2)
+	go r.runCapacityAdjuster()
+	go r.runReclaimer()
+
+	return r
+}
+
+// SetPositionTracker wires up the EventSortEngine that evicted events
+// should be refetched from. It's a no-op to evict before this is called.
+func (r *redoEventCache) SetPositionTracker(tracker TablePositionTracker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tracker = tracker
+}
+
+// Close stops the background capacity-adjuster and reclaimer goroutines.
+// It must be called once the cache is no longer in use.
+func (r *redoEventCache) Close() {
+	close(r.closeCh)
+	r.wg.Wait()
+}
+
+func (r *redoEventCache) runCapacityAdjuster() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(capacityAdjustInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.closeCh:
+			return
+		case <-ticker.C:
+			r.adjustCapacity()
+		}
+	}
+}
+
+// adjustCapacity recomputes the effective capacity from the sum of every
+// table's ingress EWMA, aiming to hold targetLatency worth of events -- or
+// latencyEWMA worth, if the sink is currently running slower than that, so
+// a lagging sink gets more buffer instead of having its backlog evicted.
+func (r *redoEventCache) adjustCapacity() {
+	r.mu.Lock()
+	var totalIngress float64
+	for _, item := range r.tables {
+		totalIngress += item.ingressEWMA
+	}
+	target := r.targetLatency
+	if r.latencyEWMA > target {
+		target = r.latencyEWMA
+	}
+
+	next := uint64(totalIngress * target.Seconds())
+	if next < r.minCapacity {
+		next = r.minCapacity
+	}
+	if next > r.maxCapacity {
+		next = r.maxCapacity
+	}
+	r.capacity = next
+	r.recomputeShareLocked()
+	r.cond.Broadcast()
+	r.mu.Unlock()
+}
+
+func (r *redoEventCache) runReclaimer() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(reclaimInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.closeCh:
+			return
+		case <-ticker.C:
+			r.reclaim()
+		}
+	}
+}
+
+// reclaim evicts ready events, most-over-share table first, until
+// `allocated` drops to lowWatermarkRatio of softCapacity or there's
+// nothing left that can be evicted without touching an in-flight
+// transaction.
+func (r *redoEventCache) reclaim() {
+	r.mu.Lock()
+	if r.softCapacity == 0 || r.tracker == nil || r.allocated <= r.softCapacity {
+		r.mu.Unlock()
+		return
+	}
+	lowWatermark := uint64(float64(r.softCapacity) * lowWatermarkRatio)
+	tracker := r.tracker
+	candidates := r.reclaimOrderLocked()
+	r.mu.Unlock()
+
+	for _, item := range candidates {
+		freed, count, pos, empty, ok := item.evictReady()
+
+		r.mu.Lock()
+		if ok {
+			r.allocated -= freed
+			item.tableAllocated -= freed
+			r.metricRedoEventCache.Sub(float64(freed))
+			item.metricTableUsage.Sub(float64(freed))
+			item.metricEvictions.Add(float64(count))
+			if empty {
+				r.deleteTableLocked(item)
+			}
+		}
+		done := r.allocated <= lowWatermark
+		r.mu.Unlock()
+
+		if ok && tracker != nil {
+			tracker.ResetPosition(item.tableID, pos)
+		}
+		if done {
+			return
+		}
+	}
+}
+
+// reclaimOrderLocked returns every tracked table ordered by how far past
+// its weighted share (tableAllocated - tableCapacity) it is, most-over
+// first, so a high-weight table that's merely idle for a moment doesn't
+// get reclaimed ahead of a low-weight table that's chronically over its
+// quota. Ties (most commonly, several tables with no share at all) break
+// toward whichever table was touched longest ago, per the LRU list. r.mu
+// must be held by the caller.
+func (r *redoEventCache) reclaimOrderLocked() []*eventAppender {
+	lruRank := make(map[*eventAppender]int, len(r.tables))
+	rank := 0
+	for elem := r.lru.Back(); elem != nil; elem = elem.Prev() {
+		lruRank[elem.Value.(*eventAppender)] = rank
+		rank++
+	}
+
+	items := make([]*eventAppender, 0, len(r.tables))
+	for _, item := range r.tables {
+		items = append(items, item)
 	}
+	sort.Slice(items, func(i, j int) bool {
+		overI := int64(items[i].tableAllocated) - int64(items[i].tableCapacity)
+		overJ := int64(items[j].tableAllocated) - int64(items[j].tableCapacity)
+		if overI != overJ {
+			return overI > overJ
+		}
+		// Lower rank means closer to the LRU back, i.e. colder.
+		return lruRank[items[i]] < lruRank[items[j]]
+	})
+	return items
 }
 
 // getAppender returns an eventAppender instance which can be used to
-// append events into the cache.
+// append events into the cache. Newly seen tables start out with
+// `defaultTableWeight`; use SetTableWeight to bias the shared budget
+// toward (or away from) a table afterwards.
 func (r *redoEventCache) getAppender(tableID model.TableID) *eventAppender {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	item, exists := r.tables[tableID]
 	if !exists {
-		item = &eventAppender{capacity: r.capacity, cache: r}
+		tableIDStr := strconv.FormatInt(tableID, 10)
+		item = &eventAppender{
+			tableID: tableID,
+			weight:  defaultTableWeight,
+			cache:   r,
+			metricTableUsage: r.metricTableUsage.WithLabelValues(
+				r.changefeedID.Namespace, r.changefeedID.ID, tableIDStr),
+			metricEvictions: r.metricEvictions.WithLabelValues(
+				r.changefeedID.Namespace, r.changefeedID.ID, tableIDStr),
+			metricIngressRate: r.metricIngressRate.WithLabelValues(
+				r.changefeedID.Namespace, r.changefeedID.ID, tableIDStr),
+			metricEgressRate: r.metricEgressRate.WithLabelValues(
+				r.changefeedID.Namespace, r.changefeedID.ID, tableIDStr),
+		}
 		r.tables[tableID] = item
+		r.totalWeight += defaultTableWeight
+		r.recomputeShareLocked()
 	}
+	r.touchLocked(item)
 	return item
 }
 
+// SetTableWeight adjusts the weighted share of the global budget that
+// tableID is entitled to, so the scheduler can bias the cache toward
+// lagging tables. It's a no-op for tables that don't have an appender yet.
+func (r *redoEventCache) SetTableWeight(tableID model.TableID, weight uint64) {
+	if weight == 0 {
+		weight = defaultTableWeight
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, exists := r.tables[tableID]
+	if !exists {
+		return
+	}
+	r.totalWeight = r.totalWeight - item.weight + weight
+	item.weight = weight
+	r.recomputeShareLocked()
+}
+
+// recomputeShareLocked recalculates every tracked table's weighted share
+// of `capacity`. r.mu must be held by the caller.
+func (r *redoEventCache) recomputeShareLocked() {
+	if r.totalWeight == 0 {
+		return
+	}
+	for _, item := range r.tables {
+		share := r.capacity * item.weight / r.totalWeight
+		if min := minTableShare(r.capacity); share < min {
+			share = min
+		}
+		item.tableCapacity = share
+	}
+}
+
+// minTableShare reserves a small slice of the global budget for every
+// table so a single low-weight table is never starved completely.
+func minTableShare(capacity uint64) uint64 {
+	if share := capacity / 100; share > 0 {
+		return share
+	}
+	return 1
+}
+
+// touchLocked moves item to the front of the LRU list, marking it as the
+// most recently used table. r.mu must be held by the caller.
+func (r *redoEventCache) touchLocked(item *eventAppender) {
+	if item.lruElem == nil {
+		item.lruElem = r.lru.PushFront(item)
+	} else {
+		r.lru.MoveToFront(item.lruElem)
+	}
+}
+
+// removeFromLRULocked drops item from the LRU list. r.mu must be held.
+func (r *redoEventCache) removeFromLRULocked(item *eventAppender) {
+	if item.lruElem != nil {
+		r.lru.Remove(item.lruElem)
+		item.lruElem = nil
+	}
+}
+
+// reserve blocks until `size` bytes are available to tableID's appender
+// under both the global and the table's weighted watermark, returning
+// false if blockTimeout elapses first.
+func (r *redoEventCache) reserve(e *eventAppender, size uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deadline := time.Now().Add(r.blockTimeout)
+	for {
+		globalHigh := uint64(float64(r.capacity) * highWatermarkRatio)
+		tableHigh := uint64(float64(e.tableCapacity) * highWatermarkRatio)
+		if r.allocated+size <= globalHigh && e.tableAllocated+size <= tableHigh {
+			r.allocated += size
+			e.tableAllocated += size
+			e.sampleIngressLocked(size)
+			r.touchLocked(e)
+			return true
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false
+		}
+		r.waitLocked(remaining)
+	}
+}
+
+// waitLocked blocks on r.cond for at most `d`. r.mu must be held on
+// entry and is held again on return.
+func (r *redoEventCache) waitLocked(d time.Duration) {
+	timer := time.AfterFunc(d, func() {
+		r.mu.Lock()
+		r.cond.Broadcast()
+		r.mu.Unlock()
+	})
+	defer timer.Stop()
+	r.cond.Wait()
+}
+
+// release gives `size` bytes back to the global and per-table budgets,
+// samples e's egress EWMA and (if positive) the cache-wide latency EWMA,
+// and wakes any push calls blocked in reserve. r.mu must not be held by
+// the caller.
+func (r *redoEventCache) release(e *eventAppender, size uint64, latency time.Duration) {
+	r.mu.Lock()
+	r.allocated -= size
+	e.tableAllocated -= size
+	e.sampleEgressLocked(size)
+	if latency > 0 {
+		r.latencyEWMA = time.Duration(ewmaAlpha*float64(latency) + (1-ewmaAlpha)*float64(r.latencyEWMA))
+	}
+	r.touchLocked(e)
+	r.cond.Broadcast()
+	r.mu.Unlock()
+
+	r.metricRedoEventCache.Sub(float64(size))
+	e.metricTableUsage.Sub(float64(size))
+}
+
 // pop some events from the cache.
 func (r *redoEventCache) pop(
 	tableID model.TableID,
@@ -73,8 +537,8 @@ func (r *redoEventCache) pop(
 	r.mu.Unlock()
 
 	item.mu.RLock()
-	defer item.mu.RUnlock()
 	if len(item.events) == 0 || item.readyCount == 0 {
+		item.mu.RUnlock()
 		return nil, 0, engine.Position{}
 	}
 
@@ -88,6 +552,7 @@ func (r *redoEventCache) pop(
 			return pos.Compare(upperBound[0]) > 0
 		})
 		if fetchCount == 0 {
+			item.mu.RUnlock()
 			return nil, 0, engine.Position{}
 		}
 	}
@@ -106,21 +571,181 @@ func (r *redoEventCache) pop(
 		CommitTs: item.events[fetchCount-1].CommitTs,
 		StartTs:  item.events[fetchCount-1].StartTs,
 	}
+	latency := time.Since(item.pushTimes[0])
 
 	item.events = item.events[fetchCount:]
 	item.sizes = item.sizes[fetchCount:]
 	item.pushCounts = item.pushCounts[fetchCount:]
-	if len(item.events) == 0 {
-		r.mu.Lock()
-		delete(r.tables, tableID)
+	item.pushTimes = item.pushTimes[fetchCount:]
+	item.readyCount -= fetchCount
+	empty := len(item.events) == 0
+	item.mu.RUnlock()
+
+	// deleteIfEmpty takes r.mu before item.mu, the same order removeTable
+	// uses, and re-checks emptiness itself -- so this never nests the
+	// locks the other way around the way calling it while item.mu is
+	// still held would. release runs first, since it touches the LRU list
+	// and would otherwise put a just-deleted appender back on it.
+	r.release(item, size, latency)
+	if empty {
+		r.deleteIfEmpty(tableID, item)
+	}
+	return events, size, pos
+}
+
+// PipelinedBatch is one independent push/pushBatch boundary's worth of
+// ready events, as returned by popPipeline.
+type PipelinedBatch struct {
+	Events   []*model.RowChangedEvent
+	Size     uint64
+	Position engine.Position
+}
+
+// popPipeline is like pop, but instead of collapsing the whole ready
+// prefix into one slice, it returns up to maxBatches independent slices,
+// one per pushBatch boundary (reconstructed from pushCounts), each with
+// its own engine.Position marker. This lets a downstream consumer, e.g.
+// the redo worker, pipeline writes for multiple transactions to the redo
+// writer concurrently while still acknowledging them in order. It stops
+// once maxBatches batches have been collected or the next batch would
+// push the total past maxBytes, whichever comes first; maxBytes == 0
+// means unbounded. The plain, single-batch pop above remains available
+// for callers that want simple semantics.
+//
+// This is a building block, not yet plumbed anywhere: the redo worker
+// loop still calls pop one transaction at a time, and there's no
+// changefeed config knob controlling maxBatches/maxBytes in this tree.
+// Wiring popPipeline into that loop and exposing the knob is follow-up
+// work.
+func (r *redoEventCache) popPipeline(
+	tableID model.TableID,
+	maxBatches int,
+	maxBytes uint64,
+	upperBound ...engine.Position,
+) ([]PipelinedBatch, engine.Position) {
+	if maxBatches <= 0 {
+		return nil, engine.Position{}
+	}
+
+	r.mu.Lock()
+	item, exists := r.tables[tableID]
+	if !exists {
 		r.mu.Unlock()
-	} else {
-		item.readyCount -= fetchCount
+		return nil, engine.Position{}
 	}
+	r.mu.Unlock()
 
-	atomic.AddUint64(&r.allocated, ^(size - 1))
-	r.metricRedoEventCache.Sub(float64(size))
-	return events, size, pos
+	item.mu.RLock()
+	if len(item.events) == 0 || item.readyCount == 0 {
+		item.mu.RUnlock()
+		return nil, engine.Position{}
+	}
+
+	limit := item.readyCount
+	if len(upperBound) > 0 {
+		limit = sort.Search(item.readyCount, func(i int) bool {
+			pos := engine.Position{
+				CommitTs: item.events[i].CommitTs,
+				StartTs:  item.events[i].StartTs,
+			}
+			return pos.Compare(upperBound[0]) > 0
+		})
+		if limit == 0 {
+			item.mu.RUnlock()
+			return nil, engine.Position{}
+		}
+	}
+
+	// A nonzero pushCounts[i] marks the first event of a push/pushBatch
+	// call; everything up to (but not including) the next nonzero entry
+	// came from that same call.
+	boundaries := []int{0}
+	for i := 1; i < limit; i++ {
+		if item.pushCounts[i] != 0 {
+			boundaries = append(boundaries, i)
+		}
+	}
+
+	var batches []PipelinedBatch
+	var totalBytes uint64
+	fetchCount := 0
+	for k, start := range boundaries {
+		if len(batches) >= maxBatches {
+			break
+		}
+		end := limit
+		if k+1 < len(boundaries) {
+			end = boundaries[k+1]
+		}
+		var size uint64
+		for _, x := range item.sizes[start:end] {
+			size += x
+		}
+		// Always take at least one batch, even if it alone exceeds
+		// maxBytes, so popPipeline can't stall forever on an oversized
+		// transaction.
+		if len(batches) > 0 && maxBytes > 0 && totalBytes+size > maxBytes {
+			break
+		}
+		batches = append(batches, PipelinedBatch{
+			Events: item.events[start:end],
+			Size:   size,
+			Position: engine.Position{
+				CommitTs: item.events[end-1].CommitTs,
+				StartTs:  item.events[end-1].StartTs,
+			},
+		})
+		totalBytes += size
+		fetchCount = end
+	}
+	if len(batches) == 0 {
+		item.mu.RUnlock()
+		return nil, engine.Position{}
+	}
+
+	latency := time.Since(item.pushTimes[0])
+
+	item.events = item.events[fetchCount:]
+	item.sizes = item.sizes[fetchCount:]
+	item.pushCounts = item.pushCounts[fetchCount:]
+	item.pushTimes = item.pushTimes[fetchCount:]
+	item.readyCount -= fetchCount
+	empty := len(item.events) == 0
+	item.mu.RUnlock()
+
+	// See the identical comment in pop: deleteIfEmpty re-acquires the
+	// locks in r.mu-then-item.mu order instead of nesting them the other
+	// way while item.mu is still held, and release runs before it.
+	r.release(item, totalBytes, latency)
+	if empty {
+		r.deleteIfEmpty(tableID, item)
+	}
+	return batches, batches[len(batches)-1].Position
+}
+
+// deleteIfEmpty removes tableID from the cache if its appender still has
+// no buffered events. It acquires r.mu then item.mu, the same order
+// removeTable uses, so it never inverts lock order against a concurrent
+// removeTable call. It re-checks emptiness under item.mu because the
+// caller's own check (taken, then dropped, before calling this) may be
+// stale: a concurrent push could have raced in after the caller released
+// item.mu and before deleteIfEmpty re-acquires it.
+func (r *redoEventCache) deleteIfEmpty(tableID model.TableID, item *eventAppender) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cur, exists := r.tables[tableID]; !exists || cur != item {
+		return
+	}
+
+	item.mu.Lock()
+	empty := len(item.events) == 0
+	item.mu.Unlock()
+	if !empty {
+		return
+	}
+
+	r.deleteTableLocked(item)
 }
 
 func (r *redoEventCache) removeTable(tableID model.TableID) {
@@ -130,16 +755,58 @@ func (r *redoEventCache) removeTable(tableID model.TableID) {
 	if exists {
 		item.mu.Lock()
 		defer item.mu.Unlock()
-		delete(r.tables, tableID)
+		r.deleteTableLocked(item)
 		item.events = nil
 		item.sizes = nil
 		item.pushCounts = nil
+		item.pushTimes = nil
 	}
 }
 
+// deleteTableLocked drops item from r.tables and the LRU/weight
+// bookkeeping, and deletes its four per-table Prometheus series. Without
+// the latter, a long-running changefeed whose tables come and go (DDL,
+// rebalancing) would leak one series per metric per table ID for the
+// life of the process. r.mu must be held by the caller.
+func (r *redoEventCache) deleteTableLocked(item *eventAppender) {
+	delete(r.tables, item.tableID)
+	r.totalWeight -= item.weight
+	r.recomputeShareLocked()
+	r.removeFromLRULocked(item)
+
+	tableIDStr := strconv.FormatInt(item.tableID, 10)
+	r.metricTableUsage.DeleteLabelValues(r.changefeedID.Namespace, r.changefeedID.ID, tableIDStr)
+	r.metricEvictions.DeleteLabelValues(r.changefeedID.Namespace, r.changefeedID.ID, tableIDStr)
+	r.metricIngressRate.DeleteLabelValues(r.changefeedID.Namespace, r.changefeedID.ID, tableIDStr)
+	r.metricEgressRate.DeleteLabelValues(r.changefeedID.Namespace, r.changefeedID.ID, tableIDStr)
+}
+
 type eventAppender struct {
-	capacity uint64
-	cache    *redoEventCache
+	tableID model.TableID
+	cache   *redoEventCache
+
+	weight         uint64        // the table's weighted share of cache.capacity.
+	tableCapacity  uint64        // recomputed as weights or capacity change; guarded by cache.mu.
+	tableAllocated uint64        // bytes currently held by this table; guarded by cache.mu.
+	lruElem        *list.Element // this table's node in cache.lru; guarded by cache.mu.
+
+	// ingressEWMA and egressEWMA are this table's push/pop rate, in bytes
+	// per second; lastIngressSample/lastEgressSample are when each was
+	// last updated. All four are guarded by cache.mu, since they're only
+	// ever touched from reserve/release, which already hold it. Keeping
+	// them per table (rather than cache-wide) means one table's push/pop
+	// pattern can't stomp another's rate estimate, and adjustCapacity can
+	// size the cache off their sum instead of one shared, meaningless
+	// average.
+	ingressEWMA       float64
+	egressEWMA        float64
+	lastIngressSample time.Time
+	lastEgressSample  time.Time
+
+	metricTableUsage  prometheus.Gauge
+	metricEvictions   prometheus.Counter
+	metricIngressRate prometheus.Gauge
+	metricEgressRate  prometheus.Gauge
 
 	broken bool
 
@@ -148,6 +815,10 @@ type eventAppender struct {
 	sizes      []uint64
 	readyCount int // Count of ready events
 
+	// pushTimes[i] is when events[i] was pushed, used to sample the
+	// cache's sink-latency EWMA once the event is popped.
+	pushTimes []time.Time
+
 	// Several RowChangedEvent can come from one PolymorphicEvent.
 	pushCounts []byte
 }
@@ -158,32 +829,30 @@ func (e *eventAppender) push(
 	eventsInSameBatch ...*model.RowChangedEvent,
 ) bool {
 	// At most only one client can call push on a given eventAppender instance,
-	// so lock is unnecessary.
+	// so lock is unnecessary for `broken`.
 	if e.broken {
 		return false
 	}
 
-	for {
-		allocated := atomic.LoadUint64(&e.cache.allocated)
-		if allocated >= e.capacity {
-			e.broken = true
-			return false
-		}
-		if atomic.CompareAndSwapUint64(&e.cache.allocated, allocated, allocated+size) {
-			e.cache.metricRedoEventCache.Add(float64(size))
-			break
-		}
+	if !e.cache.reserve(e, size) {
+		e.broken = true
+		return false
 	}
+	e.cache.metricRedoEventCache.Add(float64(size))
+	e.metricTableUsage.Add(float64(size))
 
+	now := time.Now()
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	e.events = append(e.events, event)
 	e.sizes = append(e.sizes, size)
 	e.pushCounts = append(e.pushCounts, 1)
+	e.pushTimes = append(e.pushTimes, now)
 	for _, event := range eventsInSameBatch {
 		e.events = append(e.events, event)
 		e.sizes = append(e.sizes, 0)
 		e.pushCounts = append(e.pushCounts, 0)
+		e.pushTimes = append(e.pushTimes, now)
 	}
 	if txnFinished {
 		e.readyCount = len(e.events)
@@ -199,10 +868,36 @@ func (e *eventAppender) pushBatch(events []*model.RowChangedEvent, size uint64,
 	return e.push(events[0], size, txnFinished, events[1:]...)
 }
 
+// sampleIngressLocked samples e's ingress EWMA from a size-byte push.
+// cache.mu must be held.
+func (e *eventAppender) sampleIngressLocked(size uint64) {
+	now := time.Now()
+	elapsed := now.Sub(e.lastIngressSample).Seconds()
+	if elapsed <= 0 {
+		elapsed = minSampleInterval.Seconds()
+	}
+	rate := float64(size) / elapsed
+	e.ingressEWMA = ewmaAlpha*rate + (1-ewmaAlpha)*e.ingressEWMA
+	e.lastIngressSample = now
+	e.metricIngressRate.Set(e.ingressEWMA)
+}
+
+// sampleEgressLocked samples e's egress EWMA from a size-byte pop.
+// cache.mu must be held.
+func (e *eventAppender) sampleEgressLocked(size uint64) {
+	now := time.Now()
+	elapsed := now.Sub(e.lastEgressSample).Seconds()
+	if elapsed <= 0 {
+		elapsed = minSampleInterval.Seconds()
+	}
+	rate := float64(size) / elapsed
+	e.egressEWMA = ewmaAlpha*rate + (1-ewmaAlpha)*e.egressEWMA
+	e.lastEgressSample = now
+	e.metricEgressRate.Set(e.egressEWMA)
+}
+
 func (e *eventAppender) cleanBrokenEvents() (pendingSize uint64) {
 	e.mu.Lock()
-	defer e.mu.Unlock()
-
 	for i := e.readyCount; i < len(e.events); i++ {
 		pendingSize += e.sizes[i]
 		e.events[i] = nil
@@ -211,10 +906,46 @@ func (e *eventAppender) cleanBrokenEvents() (pendingSize uint64) {
 	e.events = e.events[0:e.readyCount]
 	e.sizes = e.sizes[0:e.readyCount]
 	e.pushCounts = e.pushCounts[0:e.readyCount]
+	e.pushTimes = e.pushTimes[0:e.readyCount]
 
 	e.broken = false
-	atomic.AddUint64(&e.cache.allocated, ^(pendingSize - 1))
-	e.cache.metricRedoEventCache.Sub(float64(pendingSize))
+	e.mu.Unlock()
+
+	// release takes cache.mu; it must run after e.mu is dropped so this
+	// never nests the locks in the opposite order from removeTable.
+	e.cache.release(e, pendingSize, 0)
 
 	return
 }
+
+// evictReady drops this table's ready events (those at or before
+// readyCount, i.e. belonging to a finished transaction) from the cache.
+// It never touches events past readyCount, since those are part of an
+// in-flight transaction and must stay intact. It returns the bytes and
+// event count freed, and the position of the first evicted event so the
+// caller can ask the engine to refetch from there.
+func (e *eventAppender) evictReady() (freed uint64, count int, pos engine.Position, empty bool, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.readyCount == 0 {
+		return 0, 0, engine.Position{}, false, false
+	}
+
+	count = e.readyCount
+	for _, x := range e.sizes[0:count] {
+		freed += x
+	}
+	pos = engine.Position{
+		CommitTs: e.events[0].CommitTs,
+		StartTs:  e.events[0].StartTs,
+	}
+
+	e.events = e.events[count:]
+	e.sizes = e.sizes[count:]
+	e.pushCounts = e.pushCounts[count:]
+	e.pushTimes = e.pushTimes[count:]
+	e.readyCount = 0
+
+	return freed, count, pos, len(e.events) == 0, true
+}
@@ -0,0 +1,308 @@
+// Copyright 2022 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinkmanager
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tiflow/cdc/model"
+	"github.com/pingcap/tiflow/cdc/processor/sourcemanager/engine"
+)
+
+func newTestRedoEventCache(minCapacity, maxCapacity, softCapacity uint64) *redoEventCache {
+	return newRedoEventCache(
+		model.ChangeFeedID{Namespace: "test", ID: "test"},
+		minCapacity, maxCapacity, softCapacity)
+}
+
+// TestReserveReleaseBlocking checks that push blocks once allocated crosses
+// the high watermark and unblocks as soon as a concurrent release frees
+// enough space, rather than failing or timing out.
+func TestReserveReleaseBlocking(t *testing.T) {
+	r := newTestRedoEventCache(0, 100, 0)
+	defer r.Close()
+	r.blockTimeout = time.Minute
+
+	e := r.getAppender(1)
+	if !r.reserve(e, 90) {
+		t.Fatalf("expected first reserve to succeed under the watermark")
+	}
+
+	done := make(chan bool, 1)
+	go func() {
+		done <- r.reserve(e, 10)
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("reserve should have blocked with no space free")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	r.release(e, 90, 0)
+
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatalf("blocked reserve should have succeeded once space freed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("blocked reserve never returned after release")
+	}
+}
+
+// TestReserveTimeoutMarksBroken checks that reserve gives up and returns
+// false once blockTimeout elapses with no space freed, matching what
+// eventAppender.push relies on to mark itself broken.
+func TestReserveTimeoutMarksBroken(t *testing.T) {
+	r := newTestRedoEventCache(0, 100, 0)
+	defer r.Close()
+	r.blockTimeout = 20 * time.Millisecond
+
+	e := r.getAppender(1)
+	if !r.reserve(e, 90) {
+		t.Fatalf("expected first reserve to succeed under the watermark")
+	}
+
+	start := time.Now()
+	if r.reserve(e, 10) {
+		t.Fatalf("expected reserve to time out with no space free")
+	}
+	if elapsed := time.Since(start); elapsed < r.blockTimeout {
+		t.Fatalf("reserve returned before blockTimeout elapsed: %v", elapsed)
+	}
+}
+
+// TestPopConcurrentWithRemoveTableNoDeadlock is a regression test for the
+// item.mu/r.mu lock-order inversion between pop and removeTable: pop took
+// item.mu then, via release, r.mu, while removeTable took r.mu then
+// item.mu. Running them concurrently in a tight loop against the same
+// table used to be able to deadlock; this fails by timeout if it does.
+func TestPopConcurrentWithRemoveTableNoDeadlock(t *testing.T) {
+	r := newTestRedoEventCache(0, 1<<20, 0)
+	defer r.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for i := 0; i < 200; i++ {
+			e := r.getAppender(1)
+			e.push(&model.RowChangedEvent{CommitTs: uint64(i + 1), StartTs: uint64(i)}, 1, true)
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				r.pop(1, nil)
+			}()
+			go func() {
+				defer wg.Done()
+				r.removeTable(1)
+			}()
+			wg.Wait()
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("pop/removeTable deadlocked")
+	}
+}
+
+type fakePositionTracker struct {
+	mu     sync.Mutex
+	resets map[model.TableID]engine.Position
+}
+
+func (f *fakePositionTracker) ResetPosition(tableID model.TableID, pos engine.Position) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.resets == nil {
+		f.resets = make(map[model.TableID]engine.Position)
+	}
+	f.resets[tableID] = pos
+}
+
+// TestReclaimRespectsReadyCount checks that the reclaimer only evicts
+// events belonging to finished transactions (i.e. within readyCount) and
+// never touches an in-flight transaction's still-pending events.
+func TestReclaimRespectsReadyCount(t *testing.T) {
+	r := newTestRedoEventCache(0, 1<<20, 8)
+	defer r.Close()
+	tracker := &fakePositionTracker{}
+	r.SetPositionTracker(tracker)
+
+	e := r.getAppender(1)
+	// One finished transaction (ready) ...
+	e.push(&model.RowChangedEvent{CommitTs: 1, StartTs: 0}, 5, true)
+	// ... followed by an in-flight one (not ready).
+	e.push(&model.RowChangedEvent{CommitTs: 2, StartTs: 1}, 5, false)
+
+	r.reclaim()
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if len(e.events) != 1 {
+		t.Fatalf("expected the in-flight event to survive reclaim, got %d events left", len(e.events))
+	}
+	if e.events[0].CommitTs != 2 {
+		t.Fatalf("expected the surviving event to be the in-flight one, got CommitTs=%d", e.events[0].CommitTs)
+	}
+	if _, ok := tracker.resets[1]; !ok {
+		t.Fatalf("expected reclaim to report the evicted position back to the tracker")
+	}
+}
+
+// TestReclaimEvictsColdestFirst checks that, between two tables equally
+// over their weighted share, the reclaimer evicts the least-recently-touched
+// table's ready events and stops before it touches the more recently used
+// table.
+func TestReclaimEvictsColdestFirst(t *testing.T) {
+	r := newTestRedoEventCache(0, 1<<20, 12)
+	defer r.Close()
+	r.SetPositionTracker(&fakePositionTracker{})
+
+	cold := r.getAppender(1)
+	cold.push(&model.RowChangedEvent{CommitTs: 1, StartTs: 0}, 8, true)
+
+	hot := r.getAppender(2)
+	hot.push(&model.RowChangedEvent{CommitTs: 1, StartTs: 0}, 8, true)
+	// Touch table 2 again so table 1 is the coldest (back of the LRU).
+	r.getAppender(2)
+
+	r.reclaim()
+
+	cold.mu.RLock()
+	coldLen := len(cold.events)
+	cold.mu.RUnlock()
+	hot.mu.RLock()
+	hotLen := len(hot.events)
+	hot.mu.RUnlock()
+
+	if coldLen != 0 {
+		t.Fatalf("expected the coldest table to be reclaimed first, got %d events left", coldLen)
+	}
+	if hotLen != 1 {
+		t.Fatalf("expected the hotter table to be left alone, got %d events left", hotLen)
+	}
+}
+
+// TestPopPipelineBoundaries checks that popPipeline splits the ready
+// prefix into one batch per pushBatch call (reconstructed from
+// pushCounts), rather than collapsing them the way pop does, and caps
+// the number of batches returned at maxBatches.
+func TestPopPipelineBoundaries(t *testing.T) {
+	r := newTestRedoEventCache(0, 1<<20, 0)
+	defer r.Close()
+
+	e := r.getAppender(1)
+	e.pushBatch([]*model.RowChangedEvent{
+		{CommitTs: 1, StartTs: 0},
+		{CommitTs: 1, StartTs: 0},
+	}, 10, true)
+	e.pushBatch([]*model.RowChangedEvent{{CommitTs: 2, StartTs: 1}}, 5, true)
+	e.pushBatch([]*model.RowChangedEvent{{CommitTs: 3, StartTs: 2}}, 5, true)
+
+	batches, _ := r.popPipeline(1, 2, 0)
+	if len(batches) != 2 {
+		t.Fatalf("expected maxBatches to cap the result at 2 batches, got %d", len(batches))
+	}
+	if len(batches[0].Events) != 2 {
+		t.Fatalf("expected the first batch to keep its two same-PolymorphicEvent rows together, got %d", len(batches[0].Events))
+	}
+	if len(batches[1].Events) != 1 || batches[1].Events[0].CommitTs != 2 {
+		t.Fatalf("expected the second batch to be the lone CommitTs=2 event, got %+v", batches[1].Events)
+	}
+
+	rest, _ := r.popPipeline(1, 10, 0)
+	if len(rest) != 1 || rest[0].Events[0].CommitTs != 3 {
+		t.Fatalf("expected the remaining batch to be CommitTs=3, got %+v", rest)
+	}
+}
+
+// TestPopPipelineMaxBytes checks that popPipeline stops accumulating
+// batches once the next one would push the running total past maxBytes,
+// but always takes at least one batch so it can't stall forever on an
+// oversized transaction.
+func TestPopPipelineMaxBytes(t *testing.T) {
+	r := newTestRedoEventCache(0, 1<<20, 0)
+	defer r.Close()
+
+	e := r.getAppender(1)
+	e.pushBatch([]*model.RowChangedEvent{{CommitTs: 1, StartTs: 0}}, 25, true)
+	e.pushBatch([]*model.RowChangedEvent{{CommitTs: 2, StartTs: 1}}, 5, true)
+	e.pushBatch([]*model.RowChangedEvent{{CommitTs: 3, StartTs: 2}}, 5, true)
+
+	batches, _ := r.popPipeline(1, 10, 20)
+	if len(batches) != 1 {
+		t.Fatalf("expected only the first oversized batch to be taken, got %d batches", len(batches))
+	}
+	if batches[0].Events[0].CommitTs != 1 {
+		t.Fatalf("expected the first batch taken to be CommitTs=1, got %+v", batches[0])
+	}
+
+	rest, _ := r.popPipeline(1, 10, 20)
+	if len(rest) != 2 {
+		t.Fatalf("expected both remaining small batches to fit under maxBytes together, got %d", len(rest))
+	}
+}
+
+// TestSetTableWeightSharesCapacityProportionally checks that two tables
+// with different weights get proportionally different slices of
+// capacity, that minTableShare's floor keeps a low-weight table from
+// being starved to zero, and that the lower-weight table is the one that
+// blocks first once the two compete for the same shared budget.
+func TestSetTableWeightSharesCapacityProportionally(t *testing.T) {
+	r := newTestRedoEventCache(0, 400, 0)
+	defer r.Close()
+	r.blockTimeout = 50 * time.Millisecond
+
+	light := r.getAppender(1)
+	heavy := r.getAppender(2)
+	// A 1:1000 split drives the raw weighted share for the lighter table
+	// to 0 (400*1/1001, truncated), so this also exercises the
+	// minTableShare floor that keeps it from being starved completely.
+	r.SetTableWeight(2, 1000)
+
+	if light.tableCapacity == 0 {
+		t.Fatalf("expected minTableShare to keep the weight-1 table above zero capacity")
+	}
+	if heavy.tableCapacity <= light.tableCapacity {
+		t.Fatalf("expected the weight-1000 table to get more capacity than the weight-1 table: heavy=%d light=%d",
+			heavy.tableCapacity, light.tableCapacity)
+	}
+	wantLight := minTableShare(r.capacity)
+	if light.tableCapacity != wantLight {
+		t.Fatalf("expected the weight-1 table's share to be exactly minTableShare(%d), got %d", wantLight, light.tableCapacity)
+	}
+
+	lightHigh := uint64(float64(light.tableCapacity) * highWatermarkRatio)
+	if !r.reserve(light, lightHigh) {
+		t.Fatalf("expected the weight-1 table to admit up to its own high watermark")
+	}
+	if r.reserve(light, 1) {
+		t.Fatalf("expected the weight-1 table to block once past its own share, even though the cache overall has room")
+	}
+
+	heavyHigh := uint64(float64(heavy.tableCapacity) * highWatermarkRatio)
+	// The minTableShare floor lets the two shares add up past capacity
+	// (4 + 399 > 400), so with the light table holding lightHigh (3) the
+	// global watermark (360) leaves the heavy table 357, just under its own
+	// 359; heavyHigh-lightHigh fits under both.
+	if !r.reserve(heavy, heavyHigh-lightHigh) {
+		t.Fatalf("expected the weight-1000 table to admit its own high watermark less what the weight-1 table holds")
+	}
+}